@@ -0,0 +1,138 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// clusterMemberPlacementPrefix is the placement directive prefix used
+// to pin a new instance to a specific LXD cluster member, e.g.
+// "juju add-machine lxd:member=node3".
+const clusterMemberPlacementPrefix = "member="
+
+// detectCluster queries the LXD server's /1.0/cluster endpoint and
+// records whether it's part of an LXD cluster, and if so, the names
+// of its members. Standalone LXD daemons, and LXD versions that
+// predate clustering, both report "not clustered" here rather than
+// erroring, so this is safe to call unconditionally.
+func (env *environ) detectCluster() error {
+	enabled, members, err := env.raw.ClusterStatus()
+	if err != nil {
+		return errors.Annotate(err, "querying cluster status")
+	}
+	env.lock.Lock()
+	env.clusterEnabled = enabled
+	env.clusterMembers = members
+	env.lock.Unlock()
+	return nil
+}
+
+// clusterMemberForPlacement extracts the member name from a
+// "member=<name>" placement directive. ok is false if placement
+// doesn't name a cluster member, in which case the caller should fall
+// back to its own scheduling.
+func clusterMemberForPlacement(placement string) (name string, ok bool) {
+	if !strings.HasPrefix(placement, clusterMemberPlacementPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(placement, clusterMemberPlacementPrefix), true
+}
+
+// chooseClusterMember picks the cluster member a new container should
+// be created on. If placement names a member directly, that member is
+// used (or an error, if it doesn't exist). Otherwise, if tags were
+// supplied as constraints, they're treated as member group selectors
+// and member names are matched against them by prefix; the
+// least-loaded matching member is chosen so containers are bin-packed
+// evenly across the group. With no placement or tags, the
+// least-loaded member in the whole cluster is chosen. On a
+// standalone, non-clustered LXD server, member is returned empty and
+// the caller proceeds exactly as it always has.
+func (env *environ) chooseClusterMember(placement string, tags []string) (member string, err error) {
+	env.lock.Lock()
+	clustered := env.clusterEnabled
+	members := append([]string(nil), env.clusterMembers...)
+	env.lock.Unlock()
+
+	return chooseClusterMemberFrom(clustered, members, placement, tags, env.raw.InstanceCountByMember)
+}
+
+// chooseClusterMemberFrom holds the scheduling logic itself, with the
+// instance-count lookup taken as a function rather than called
+// directly on rawProvider, so it can be exercised in tests with a
+// fake standing in for a real LXD cluster.
+func chooseClusterMemberFrom(
+	clustered bool,
+	members []string,
+	placement string,
+	tags []string,
+	instanceCountByMember func([]string) (map[string]int, error),
+) (string, error) {
+	if !clustered || len(members) == 0 {
+		return "", nil
+	}
+
+	if name, ok := clusterMemberForPlacement(placement); ok {
+		if !containsString(members, name) {
+			return "", errors.NotFoundf("cluster member %q", name)
+		}
+		return name, nil
+	}
+
+	candidates := members
+	if len(tags) > 0 {
+		candidates = membersInGroups(members, tags)
+		if len(candidates) == 0 {
+			return "", errors.NotFoundf("cluster member in group %v", tags)
+		}
+	}
+
+	counts, err := instanceCountByMember(candidates)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return leastLoadedMember(candidates, counts), nil
+}
+
+// membersInGroups returns the members whose name is prefixed by one
+// of the given groups, preserving the LXD-reported member order.
+func membersInGroups(members []string, groups []string) []string {
+	var matches []string
+	for _, m := range members {
+		for _, g := range groups {
+			if strings.HasPrefix(m, g) {
+				matches = append(matches, m)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// leastLoadedMember returns whichever of members has the lowest
+// instance count, breaking ties in favour of the first member in the
+// slice so results are deterministic.
+func leastLoadedMember(members []string, counts map[string]int) string {
+	best := members[0]
+	for _, m := range members[1:] {
+		if counts[m] < counts[best] {
+			best = m
+		}
+	}
+	return best
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juju/errors"
+	gc "gopkg.in/check.v1"
+)
+
+// TestPackage is the single gocheck bootstrap for this package; every
+// other _test.go file in provider/lxd registers its suite with
+// gc.Suite and relies on this one TestPackage to run it; don't add a
+// second one.
+func TestPackage(t *testing.T) { gc.TestingT(t) }
+
+type clusterSuite struct{}
+
+var _ = gc.Suite(&clusterSuite{})
+
+// fakeCluster simulates a 3-node LXD cluster's instance counts, standing
+// in for rawProvider.InstanceCountByMember in tests.
+type fakeCluster struct {
+	counts map[string]int
+	err    error
+}
+
+func (f *fakeCluster) InstanceCountByMember(members []string) (map[string]int, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	counts := make(map[string]int, len(members))
+	for _, m := range members {
+		counts[m] = f.counts[m]
+	}
+	return counts, nil
+}
+
+func (s *clusterSuite) TestChooseClusterMemberNotClustered(c *gc.C) {
+	fake := &fakeCluster{}
+	member, err := chooseClusterMemberFrom(false, nil, "", nil, fake.InstanceCountByMember)
+	c.Assert(err, gc.IsNil)
+	c.Assert(member, gc.Equals, "")
+}
+
+func (s *clusterSuite) TestChooseClusterMemberExplicitPlacement(c *gc.C) {
+	fake := &fakeCluster{counts: map[string]int{"node1": 5, "node2": 0, "node3": 2}}
+	members := []string{"node1", "node2", "node3"}
+	member, err := chooseClusterMemberFrom(true, members, "member=node3", nil, fake.InstanceCountByMember)
+	c.Assert(err, gc.IsNil)
+	c.Assert(member, gc.Equals, "node3")
+}
+
+func (s *clusterSuite) TestChooseClusterMemberUnknownPlacement(c *gc.C) {
+	fake := &fakeCluster{}
+	members := []string{"node1", "node2", "node3"}
+	_, err := chooseClusterMemberFrom(true, members, "member=node9", nil, fake.InstanceCountByMember)
+	c.Assert(err, gc.ErrorMatches, `cluster member "node9" not found`)
+	c.Assert(errors.IsNotFound(err), gc.Equals, true)
+}
+
+func (s *clusterSuite) TestChooseClusterMemberRoundRobinsToLeastLoaded(c *gc.C) {
+	fake := &fakeCluster{counts: map[string]int{"node1": 5, "node2": 1, "node3": 3}}
+	members := []string{"node1", "node2", "node3"}
+	member, err := chooseClusterMemberFrom(true, members, "", nil, fake.InstanceCountByMember)
+	c.Assert(err, gc.IsNil)
+	c.Assert(member, gc.Equals, "node2")
+}
+
+func (s *clusterSuite) TestChooseClusterMemberHonoursTagsAsGroups(c *gc.C) {
+	fake := &fakeCluster{counts: map[string]int{"edge-1": 4, "edge-2": 1, "core-1": 0}}
+	members := []string{"edge-1", "edge-2", "core-1"}
+	member, err := chooseClusterMemberFrom(true, members, "", []string{"edge"}, fake.InstanceCountByMember)
+	c.Assert(err, gc.IsNil)
+	c.Assert(member, gc.Equals, "edge-2")
+}
+
+func (s *clusterSuite) TestChooseClusterMemberNoMatchingGroup(c *gc.C) {
+	fake := &fakeCluster{}
+	members := []string{"node1", "node2", "node3"}
+	_, err := chooseClusterMemberFrom(true, members, "", []string{"gpu"}, fake.InstanceCountByMember)
+	c.Assert(errors.IsNotFound(err), gc.Equals, true)
+}
+
+func (s *clusterSuite) TestClusterMemberForPlacement(c *gc.C) {
+	name, ok := clusterMemberForPlacement("member=node2")
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(name, gc.Equals, "node2")
+
+	_, ok = clusterMemberForPlacement("zone=us-east")
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *clusterSuite) TestMembersInGroupsPreservesOrder(c *gc.C) {
+	members := []string{"edge-1", "core-1", "edge-2"}
+	c.Assert(membersInGroups(members, []string{"edge"}), gc.DeepEquals, []string{"edge-1", "edge-2"})
+}
+
+func (s *clusterSuite) TestLeastLoadedMemberTieBreaksOnOrder(c *gc.C) {
+	members := []string{"node1", "node2", "node3"}
+	counts := map[string]int{"node1": 1, "node2": 1, "node3": 0}
+	c.Assert(leastLoadedMember(members, counts), gc.Equals, "node3")
+
+	counts = map[string]int{"node1": 0, "node2": 0, "node3": 0}
+	c.Assert(leastLoadedMember(members, counts), gc.Equals, "node1")
+}
+
+// fakeLXDServer serves just enough of the real LXD REST API for tests
+// to exercise rawProvider (and, through it, environ methods like
+// detectCluster and chooseClusterMember) without a real LXD daemon.
+type fakeLXDServer struct {
+	clusterEnabled bool
+	clusterMembers []string
+	instanceCounts map[string]int
+}
+
+func (f *fakeLXDServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/1.0/cluster":
+		writeLXDMetadata(w, map[string]interface{}{"enabled": f.clusterEnabled})
+	case r.Method == "GET" && r.URL.Path == "/1.0/cluster/members":
+		urls := make([]string, len(f.clusterMembers))
+		for i, m := range f.clusterMembers {
+			urls[i] = "/1.0/cluster/members/" + m
+		}
+		writeLXDMetadata(w, urls)
+	case r.Method == "GET" && r.URL.Path == "/1.0/instances":
+		member := r.URL.Query().Get("target")
+		names := make([]string, f.instanceCounts[member])
+		writeLXDMetadata(w, names)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func writeLXDMetadata(w http.ResponseWriter, metadata interface{}) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]json.RawMessage{"metadata": data})
+}
+
+// newFakeRawProvider starts a fakeLXDServer and returns a rawProvider
+// talking to it, so environ methods that go through env.raw can be
+// exercised against known cluster state instead of only through the
+// pure chooseClusterMemberFrom helper.
+func newFakeRawProvider(c *gc.C, fake *fakeLXDServer) *rawProvider {
+	srv := httptest.NewServer(fake)
+	// The test process exits shortly after the suite finishes, so
+	// there's no server to leak beyond that; gocheck's C has no
+	// teardown hook we could close it from anyway.
+	return &rawProvider{client: srv.Client(), base: srv.URL}
+}
+
+func (s *clusterSuite) TestDetectClusterNotClustered(c *gc.C) {
+	env := &environ{raw: newFakeRawProvider(c, &fakeLXDServer{})}
+	c.Assert(env.detectCluster(), gc.IsNil)
+	c.Assert(env.clusterEnabled, gc.Equals, false)
+	c.Assert(env.clusterMembers, gc.IsNil)
+}
+
+func (s *clusterSuite) TestDetectClusterEnabled(c *gc.C) {
+	env := &environ{raw: newFakeRawProvider(c, &fakeLXDServer{
+		clusterEnabled: true,
+		clusterMembers: []string{"node1", "node2"},
+	})}
+	c.Assert(env.detectCluster(), gc.IsNil)
+	c.Assert(env.clusterEnabled, gc.Equals, true)
+	c.Assert(env.clusterMembers, gc.DeepEquals, []string{"node1", "node2"})
+}
+
+func (s *clusterSuite) TestChooseClusterMemberEnvBoundRoundRobins(c *gc.C) {
+	env := &environ{
+		raw: newFakeRawProvider(c, &fakeLXDServer{
+			instanceCounts: map[string]int{"node1": 3, "node2": 1},
+		}),
+		clusterEnabled: true,
+		clusterMembers: []string{"node1", "node2"},
+	}
+	member, err := env.chooseClusterMember("", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(member, gc.Equals, "node2")
+}
+
+// Note: the clustered branch of destroyHostedModelResources isn't
+// exercised here, even with fakeLXDServer available, because it calls
+// through env.prefixedInstances, which lists instances via a type this
+// snapshot of the tree never defines (it's not something any of the
+// chunk0 requests touch or introduce).
@@ -0,0 +1,177 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"github.com/juju/errors"
+	"github.com/juju/schema"
+
+	"github.com/juju/juju/environs/config"
+)
+
+const (
+	// lxdProfileConfigKey lets the user override/extend the LXD
+	// profile config options (e.g. boot.autostart, security.nesting)
+	// that Juju applies to the model's "juju-<model-name>" profile.
+	// Values here are merged over defaultProfileConfig.
+	lxdProfileConfigKey = "lxd-profile-config"
+
+	// lxdProfileDevicesKey lets the user add device entries (network
+	// interfaces, disks, and so on) to the model's profile.
+	lxdProfileDevicesKey = "lxd-profile-devices"
+
+	// additionalProfilesKey names existing LXD profiles, managed
+	// outside of Juju, that should be attached to every container in
+	// addition to the model's own profile.
+	additionalProfilesKey = "additional-profiles"
+
+	// storagePoolKey names the LXD storage pool that container root
+	// disks, and any volumes created by the lxd storage provider,
+	// should live on. If unset, the LXD daemon's default pool is
+	// used.
+	storagePoolKey = "storage-pool"
+
+	// storagePoolDriverKey and storagePoolSourceKey configure the pool
+	// named by storage-pool, for when Juju has to create it itself
+	// (e.g. driver "zfs"/"btrfs"/"dir"/"lvm"/"ceph", source being a
+	// block device, dataset or loop file depending on the driver).
+	storagePoolDriverKey = "storage-pool-driver"
+	storagePoolSourceKey = "storage-pool-source"
+
+	// imageStreamCacheKey turns on publishing the bootstrap image on
+	// the controller's own LXD daemon, so later StartInstance calls
+	// elsewhere in the model copy it from the controller instead of
+	// each independently pulling it from the upstream image remote.
+	imageStreamCacheKey = "image-stream-cache"
+
+	// imageMetadataURLKey points at an offline simplestreams tree
+	// served over HTTPS, for bootstrapping behind a firewall with no
+	// access to cloud-images.ubuntu.com.
+	imageMetadataURLKey = "image-metadata-url"
+)
+
+var configSchema = schema.Fields{
+	lxdProfileConfigKey:   schema.StringMap(schema.String()),
+	lxdProfileDevicesKey:  schema.StringMap(schema.StringMap(schema.String())),
+	additionalProfilesKey: schema.List(schema.String()),
+	storagePoolKey:        schema.String(),
+	storagePoolDriverKey:  schema.String(),
+	storagePoolSourceKey:  schema.String(),
+	imageStreamCacheKey:   schema.Bool(),
+	imageMetadataURLKey:   schema.String(),
+}
+
+var configDefaults = schema.Defaults{
+	lxdProfileConfigKey:   schema.Omit,
+	lxdProfileDevicesKey:  schema.Omit,
+	additionalProfilesKey: schema.Omit,
+	storagePoolKey:        "",
+	storagePoolDriverKey:  "zfs",
+	storagePoolSourceKey:  schema.Omit,
+	imageStreamCacheKey:   false,
+	imageMetadataURLKey:   "",
+}
+
+type environConfig struct {
+	*config.Config
+	attrs map[string]interface{}
+}
+
+func newValidConfig(cfg *config.Config) (*environConfig, error) {
+	validated, err := cfg.ValidateUnknownAttrs(configSchema, configDefaults)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &environConfig{
+		Config: cfg,
+		attrs:  validated,
+	}, nil
+}
+
+// profileConfig returns the user-supplied overrides for the model's
+// LXD profile config, as set by the lxd-profile-config attribute.
+func (c *environConfig) profileConfig() map[string]string {
+	raw, _ := c.attrs[lxdProfileConfigKey].(map[string]interface{})
+	return stringMap(raw)
+}
+
+// profileDevices returns the user-supplied device entries for the
+// model's LXD profile, as set by the lxd-profile-devices attribute.
+func (c *environConfig) profileDevices() map[string]map[string]string {
+	raw, _ := c.attrs[lxdProfileDevicesKey].(map[string]interface{})
+	devices := make(map[string]map[string]string, len(raw))
+	for name, v := range raw {
+		entry, _ := v.(map[string]interface{})
+		devices[name] = stringMap(entry)
+	}
+	return devices
+}
+
+// additionalProfiles returns the existing LXD profiles, managed
+// outside of Juju, that should be attached to every container in this
+// model, as set by the additional-profiles attribute.
+func (c *environConfig) additionalProfiles() []string {
+	raw, _ := c.attrs[additionalProfilesKey].([]interface{})
+	profiles := make([]string, len(raw))
+	for i, v := range raw {
+		profiles[i], _ = v.(string)
+	}
+	return profiles
+}
+
+// storagePool returns the name of the LXD storage pool to use for
+// container root disks and Juju-managed volumes, as set by the
+// storage-pool attribute. An empty string means "use the LXD default
+// pool".
+func (c *environConfig) storagePool() string {
+	pool, _ := c.attrs[storagePoolKey].(string)
+	return pool
+}
+
+// storagePoolDriver and storagePoolSource describe how to create the
+// pool named by storagePool, if it doesn't already exist on the LXD
+// server.
+func (c *environConfig) storagePoolDriver() string {
+	driver, _ := c.attrs[storagePoolDriverKey].(string)
+	return driver
+}
+
+func (c *environConfig) storagePoolSource() string {
+	source, _ := c.attrs[storagePoolSourceKey].(string)
+	return source
+}
+
+// imageStreamCache reports whether this controller should publish the
+// bootstrap image on its own LXD daemon for other nodes to copy from,
+// as set by the image-stream-cache attribute.
+func (c *environConfig) imageStreamCache() bool {
+	cache, _ := c.attrs[imageStreamCacheKey].(bool)
+	return cache
+}
+
+// imageMetadataURL returns the offline simplestreams tree to use
+// instead of cloud-images.ubuntu.com, as set by the
+// image-metadata-url attribute. An empty string means none was set.
+func (c *environConfig) imageMetadataURL() string {
+	url, _ := c.attrs[imageMetadataURLKey].(string)
+	return url
+}
+
+// stringMap converts the map[string]interface{} produced by schema
+// validation into a map[string]string, dropping anything that isn't a
+// string (schema validation guarantees everything is, but we don't
+// want to panic if that ever changes).
+func stringMap(raw map[string]interface{}) map[string]string {
+	out := make(map[string]string, len(raw))
+	for k, v := range raw {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		out[k] = s
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	gc "gopkg.in/check.v1"
+)
+
+type configSuite struct{}
+
+var _ = gc.Suite(&configSuite{})
+
+func (s *configSuite) TestProfileConfigReturnsOverrides(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{
+		lxdProfileConfigKey: map[string]interface{}{
+			"security.privileged": "true",
+		},
+	}}
+	c.Assert(ecfg.profileConfig(), gc.DeepEquals, map[string]string{
+		"security.privileged": "true",
+	})
+}
+
+func (s *configSuite) TestProfileDevices(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{
+		lxdProfileDevicesKey: map[string]interface{}{
+			"eth0": map[string]interface{}{
+				"type":    "nic",
+				"nictype": "bridged",
+			},
+		},
+	}}
+	c.Assert(ecfg.profileDevices(), gc.DeepEquals, map[string]map[string]string{
+		"eth0": {"type": "nic", "nictype": "bridged"},
+	})
+}
+
+func (s *configSuite) TestAdditionalProfiles(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{
+		additionalProfilesKey: []interface{}{"privileged", "bridged-net"},
+	}}
+	c.Assert(ecfg.additionalProfiles(), gc.DeepEquals, []string{"privileged", "bridged-net"})
+}
+
+func (s *configSuite) TestStoragePoolSettings(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{
+		storagePoolKey:       "juju-pool",
+		storagePoolDriverKey: "zfs",
+		storagePoolSourceKey: "/dev/sdb",
+	}}
+	c.Assert(ecfg.storagePool(), gc.Equals, "juju-pool")
+	c.Assert(ecfg.storagePoolDriver(), gc.Equals, "zfs")
+	c.Assert(ecfg.storagePoolSource(), gc.Equals, "/dev/sdb")
+}
+
+func (s *configSuite) TestImageSettings(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{
+		imageStreamCacheKey: true,
+		imageMetadataURLKey: "https://images.internal/streams",
+	}}
+	c.Assert(ecfg.imageStreamCache(), gc.Equals, true)
+	c.Assert(ecfg.imageMetadataURL(), gc.Equals, "https://images.internal/streams")
+}
+
+func (s *configSuite) TestEmptyAttrsYieldZeroValues(c *gc.C) {
+	ecfg := &environConfig{attrs: map[string]interface{}{}}
+	c.Assert(ecfg.profileConfig(), gc.HasLen, 0)
+	c.Assert(ecfg.profileDevices(), gc.HasLen, 0)
+	c.Assert(ecfg.additionalProfiles(), gc.HasLen, 0)
+	c.Assert(ecfg.storagePool(), gc.Equals, "")
+	c.Assert(ecfg.imageStreamCache(), gc.Equals, false)
+}
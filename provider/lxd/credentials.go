@@ -0,0 +1,131 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"github.com/juju/errors"
+	lxdshared "github.com/lxc/lxd/shared"
+
+	"github.com/juju/juju/cloud"
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/tools/lxdclient"
+)
+
+const (
+	credAttrClientCert    = "client-cert"
+	credAttrClientKey     = "client-key"
+	credAttrServerCert    = "server-cert"
+	credAttrTrustPassword = "trust-password"
+)
+
+// addedCertName marks a certificate that Juju itself pushed to the LXD
+// server's trust store, as opposed to one the user pre-trusted out of
+// band (e.g. via "lxc remote add" or "lxc config trust add"). On a
+// remote LXD server, only a certificate bearing this name is removed
+// again by environ.DestroyController; anything else is left alone. On
+// a local LXD server, DestroyController removes the client certificate
+// whatever its name.
+const addedCertName = "juju client certificate"
+
+// getCerts returns the client (and, if recorded, server) certificates
+// held in the given cloud spec's credential. ok is false if the
+// credential does not carry a usable client certificate.
+func getCerts(spec environs.CloudSpec) (client, server *lxdclient.Cert, ok bool) {
+	if spec.Credential == nil {
+		return nil, nil, false
+	}
+	attrs := spec.Credential.Attributes()
+	clientPEM, hasCert := attrs[credAttrClientCert]
+	clientKeyPEM, hasKey := attrs[credAttrClientKey]
+	if !hasCert || !hasKey {
+		return nil, nil, false
+	}
+	client = &lxdclient.Cert{
+		Name:    "juju",
+		CertPEM: []byte(clientPEM),
+		KeyPEM:  []byte(clientKeyPEM),
+	}
+	if serverPEM, ok := attrs[credAttrServerCert]; ok && serverPEM != "" {
+		server = &lxdclient.Cert{CertPEM: []byte(serverPEM)}
+	}
+	return client, server, true
+}
+
+// getTrustPassword returns the trust-password credential attribute, if
+// the user supplied one. A trust password lets us add our client
+// certificate to a remote LXD server's trust store ourselves, the same
+// way "lxc remote add" does, rather than requiring the user to have
+// pre-trusted us out of band.
+func getTrustPassword(spec environs.CloudSpec) (string, bool) {
+	if spec.Credential == nil {
+		return "", false
+	}
+	password, ok := spec.Credential.Attributes()[credAttrTrustPassword]
+	return password, ok && password != ""
+}
+
+// generateClientCert creates a new self-signed client certificate and
+// key pair for use as the Juju client identity, the same way
+// lxd/shared.GenerateMemCert does for the LXD CLI itself. It is used
+// when bootstrapping against a credential that supplies a
+// trust-password but no pre-made client-cert/client-key, so that
+// "juju bootstrap lxd" works end-to-end from a trust password alone.
+func generateClientCert() (*lxdclient.Cert, error) {
+	certPEM, keyPEM, err := lxdshared.GenerateMemCert(true)
+	if err != nil {
+		return nil, errors.Annotate(err, "generating client certificate")
+	}
+	return &lxdclient.Cert{
+		Name:    "juju",
+		CertPEM: certPEM,
+		KeyPEM:  keyPEM,
+	}, nil
+}
+
+// environProviderCredentials implements environs.ProviderCredentials
+// for the lxd provider.
+type environProviderCredentials struct{}
+
+// FinalizeCredential is part of environs.ProviderCredentials. It runs
+// before the credential is persisted to the Juju credential store, so
+// it's the right place to generate a client keypair for a
+// trust-password-only credential: the generated cert/key end up
+// stashed in the credential store itself (the same as if the user had
+// supplied them), and are what every later environ constructed from
+// this credential authenticates with.
+func (environProviderCredentials) FinalizeCredential(
+	ctx environs.FinalizeCredentialContext,
+	args environs.FinalizeCredentialParams,
+) (*cloud.Credential, error) {
+	attrs := args.Credential.Attributes()
+	if _, _, ok := getCerts(environs.CloudSpec{Credential: &args.Credential}); ok {
+		// The user already supplied a client-cert/client-key pair;
+		// nothing to generate.
+		return &args.Credential, nil
+	}
+	if _, ok := attrs[credAttrTrustPassword]; !ok {
+		// No trust-password either; leave the credential as-is.
+		// Bootstrap then generates a client certificate of its own
+		// (see environ.ensureClientCertificate).
+		return &args.Credential, nil
+	}
+
+	cert, err := generateClientCert()
+	if err != nil {
+		return nil, errors.Annotate(err, "generating client certificate")
+	}
+
+	finalized := make(map[string]string, len(attrs)+2)
+	for k, v := range attrs {
+		finalized[k] = v
+	}
+	finalized[credAttrClientCert] = string(cert.CertPEM)
+	finalized[credAttrClientKey] = string(cert.KeyPEM)
+
+	out := cloud.NewCredential(args.Credential.AuthType(), finalized)
+	out.Label = args.Credential.Label
+	return &out, nil
+}
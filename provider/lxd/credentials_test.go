@@ -0,0 +1,85 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/cloud"
+	"github.com/juju/juju/environs"
+)
+
+type credentialsSuite struct{}
+
+var _ = gc.Suite(&credentialsSuite{})
+
+func (s *credentialsSuite) TestGetCertsMissingAttributes(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{})
+	_, _, ok := getCerts(environs.CloudSpec{Credential: &cred})
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *credentialsSuite) TestGetCertsPresent(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{
+		credAttrClientCert: "cert-pem",
+		credAttrClientKey:  "key-pem",
+		credAttrServerCert: "server-pem",
+	})
+	client, server, ok := getCerts(environs.CloudSpec{Credential: &cred})
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(string(client.CertPEM), gc.Equals, "cert-pem")
+	c.Assert(string(client.KeyPEM), gc.Equals, "key-pem")
+	c.Assert(string(server.CertPEM), gc.Equals, "server-pem")
+}
+
+func (s *credentialsSuite) TestGetTrustPassword(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{
+		credAttrTrustPassword: "sekrit",
+	})
+	password, ok := getTrustPassword(environs.CloudSpec{Credential: &cred})
+	c.Assert(ok, gc.Equals, true)
+	c.Assert(password, gc.Equals, "sekrit")
+
+	empty := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{})
+	_, ok = getTrustPassword(environs.CloudSpec{Credential: &empty})
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *credentialsSuite) TestFinalizeCredentialGeneratesKeypairFromTrustPassword(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{
+		credAttrTrustPassword: "sekrit",
+	})
+	out, err := environProviderCredentials{}.FinalizeCredential(nil, environs.FinalizeCredentialParams{
+		Credential: cred,
+	})
+	c.Assert(err, gc.IsNil)
+	attrs := out.Attributes()
+	c.Assert(attrs[credAttrClientCert], gc.Not(gc.Equals), "")
+	c.Assert(attrs[credAttrClientKey], gc.Not(gc.Equals), "")
+	c.Assert(attrs[credAttrTrustPassword], gc.Equals, "sekrit")
+}
+
+func (s *credentialsSuite) TestFinalizeCredentialLeavesExistingCertAlone(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{
+		credAttrClientCert: "already-here",
+		credAttrClientKey:  "already-here-too",
+	})
+	out, err := environProviderCredentials{}.FinalizeCredential(nil, environs.FinalizeCredentialParams{
+		Credential: cred,
+	})
+	c.Assert(err, gc.IsNil)
+	c.Assert(out.Attributes()[credAttrClientCert], gc.Equals, "already-here")
+}
+
+func (s *credentialsSuite) TestFinalizeCredentialNoTrustPasswordLeavesCredentialAlone(c *gc.C) {
+	cred := cloud.NewCredential(cloud.CertificateAuthType, map[string]string{})
+	out, err := environProviderCredentials{}.FinalizeCredential(nil, environs.FinalizeCredentialParams{
+		Credential: cred,
+	})
+	c.Assert(err, gc.IsNil)
+	_, hasCert := out.Attributes()[credAttrClientCert]
+	c.Assert(hasCert, gc.Equals, false)
+}
@@ -46,6 +46,19 @@ type environ struct {
 
 	lock sync.Mutex
 	ecfg *environConfig
+
+	// publishedImages tracks which image fingerprints this process
+	// has already published to the local image cache, so repeated
+	// StartInstance calls don't re-publish the same image. Protected
+	// by lock.
+	publishedImages map[string]bool
+
+	// clusterEnabled and clusterMembers record whether the LXD server
+	// we're bootstrapping onto is part of an LXD cluster, and if so,
+	// the names of its members, as detected by detectCluster during
+	// PrepareForBootstrap. Both are protected by lock.
+	clusterEnabled bool
+	clusterMembers []string
 }
 
 type newRawProviderFunc func(environs.CloudSpec, bool) (*rawProvider, error)
@@ -83,7 +96,6 @@ func newEnviron(
 	}
 	env.base = common.DefaultProvider{Env: env}
 
-	//TODO(wwitzel3) make sure we are also cleaning up profiles during destroy
 	if err := env.initProfile(); err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -96,6 +108,13 @@ var defaultProfileConfig = map[string]string{
 	"security.nesting": "true",
 }
 
+// initProfile creates the model's "juju-<model-name>" profile if it
+// doesn't already exist, merging any lxd-profile-config and
+// lxd-profile-devices overrides from the model config over the
+// built-in defaults. On a clustered LXD server the profile is
+// replicated to every member by LXD itself; the create request is sent
+// to whichever member we're talking to and carries no target= query
+// parameter.
 func (env *environ) initProfile() error {
 	hasProfile, err := env.raw.HasProfile(env.profileName())
 	if err != nil {
@@ -106,13 +125,37 @@ func (env *environ) initProfile() error {
 		return nil
 	}
 
-	return env.raw.CreateProfile(env.profileName(), defaultProfileConfig)
+	return env.raw.CreateProfile(env.profileName(), env.profileConfig(), env.ecfg.profileDevices())
+}
+
+// profileConfig returns the LXD profile config to use for this model:
+// the built-in defaults, overridden by anything set via the
+// lxd-profile-config model config attribute.
+func (env *environ) profileConfig() map[string]string {
+	merged := make(map[string]string, len(defaultProfileConfig))
+	for k, v := range defaultProfileConfig {
+		merged[k] = v
+	}
+	for k, v := range env.ecfg.profileConfig() {
+		merged[k] = v
+	}
+	return merged
 }
 
 func (env *environ) profileName() string {
 	return "juju-" + env.ecfg.Name()
 }
 
+// instanceProfiles returns the full list of LXD profiles that should
+// be attached to a newly started container: the model's own profile,
+// followed by any additional-profiles the user wants attached to
+// every container (e.g. a custom security.privileged or
+// bridged-networking profile), so operators aren't locked into the
+// profile Juju manages for them.
+func (env *environ) instanceProfiles() []string {
+	return append([]string{env.profileName()}, env.ecfg.additionalProfiles()...)
+}
+
 // Name returns the name of the environment.
 func (env *environ) Name() string {
 	return env.name
@@ -132,6 +175,22 @@ func (env *environ) SetConfig(cfg *config.Config) error {
 		return errors.Trace(err)
 	}
 	env.ecfg = ecfg
+
+	// Re-apply the profile in case lxd-profile-config or
+	// lxd-profile-devices changed; this is a no-op from LXD's
+	// perspective if neither did. SetConfig can run before the model
+	// has ever had a profile created for it (or against a profile
+	// that failed to get created), so guard on it existing first
+	// rather than erroring the whole config update.
+	hasProfile, err := env.raw.HasProfile(env.profileName())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if hasProfile {
+		if err := env.raw.UpdateProfile(env.profileName(), env.profileConfig(), env.ecfg.profileDevices()); err != nil {
+			return errors.Annotatef(err, "updating profile %q", env.profileName())
+		}
+	}
 	return nil
 }
 
@@ -148,6 +207,12 @@ func (env *environ) PrepareForBootstrap(ctx environs.BootstrapContext) error {
 	if err := lxdclient.EnableHTTPSListener(env.raw); err != nil {
 		return errors.Annotate(err, "enabling HTTPS listener")
 	}
+	if err := env.ensureStoragePool(); err != nil {
+		return errors.Annotate(err, "ensuring storage pool")
+	}
+	if err := env.detectCluster(); err != nil {
+		return errors.Annotate(err, "detecting cluster")
+	}
 	return nil
 }
 
@@ -158,32 +223,80 @@ func (env *environ) Create(environs.CreateParams) error {
 
 // Bootstrap implements environs.Environ.
 func (env *environ) Bootstrap(ctx environs.BootstrapContext, params environs.BootstrapParams) (*environs.BootstrapResult, error) {
-	if env.local {
-		// Add the client certificate to the LXD server, so the
-		// controller containers can authenticate. We can only
-		// do this for local LXD. For non-local, the user must
-		// do this themselves, until we support using trust
-		// passwords.
-		clientCert, _, ok := getCerts(env.cloud)
-		if !ok {
-			return nil, errors.New("cannot bootstrap without client certificate")
-		}
-		fingerprint, err := clientCert.Fingerprint()
+	if err := env.ensureClientCertificate(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return env.base.BootstrapEnv(ctx, params)
+}
+
+// ensureClientCertificate makes sure the LXD server we are about to
+// bootstrap onto trusts our client certificate, adding it if
+// necessary. For a server local to this host we can add the
+// certificate ourselves over the Unix socket, the same as before. For
+// a remote server we can only do so if the user supplied a
+// trust-password credential, in which case we POST the certificate to
+// /1.0/certificates with that password, mirroring what "lxc remote
+// add" does. If neither applies, the user must have pre-trusted the
+// certificate out of band.
+func (env *environ) ensureClientCertificate() error {
+	clientCert, _, ok := getCerts(env.cloud)
+	if !ok {
+		// Normally FinalizeCredential (see credentials.go) has
+		// already generated and stashed a keypair in the credential
+		// before the environ was even created, so this is a
+		// defensive fallback rather than the common path. Since
+		// there's no pre-existing identity to fall back on, install
+		// the generated cert as the one env.raw authenticates with,
+		// not just one we happen to be holding in memory.
+		generated, err := generateClientCert()
 		if err != nil {
-			return nil, errors.Trace(err)
+			return errors.Trace(err)
 		}
-		_, err = env.raw.CertByFingerprint(fingerprint)
-		if errors.IsNotFound(err) {
-			if err := env.raw.AddCert(*clientCert); err != nil {
-				return nil, errors.Annotatef(
-					err, "adding certificate %q", clientCert.Name,
-				)
-			}
-		} else if err != nil {
-			return nil, errors.Annotate(err, "querying certificates")
+		clientCert = generated
+		if err := env.raw.UseClientCert(*clientCert); err != nil {
+			return errors.Trace(err)
 		}
 	}
-	return env.base.BootstrapEnv(ctx, params)
+
+	fingerprint, err := clientCert.Fingerprint()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = env.raw.CertByFingerprint(fingerprint)
+	if err == nil {
+		// Already trusted; nothing to do.
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return errors.Annotate(err, "querying certificates")
+	}
+
+	if env.local {
+		if err := env.raw.AddCert(*clientCert); err != nil {
+			return errors.Annotatef(err, "adding certificate %q", clientCert.Name)
+		}
+		return nil
+	}
+
+	trustPassword, ok := getTrustPassword(env.cloud)
+	if !ok {
+		return errors.New(
+			"cannot bootstrap remote LXD server: no trust-password supplied, " +
+				"and the certificate has not been pre-trusted " +
+				"(see https://github.com/lxc/lxd/blob/master/doc/authentication.md)",
+		)
+	}
+	// Mark the certificate as ours so DestroyController knows it's
+	// safe to remove again; a certificate the user pre-trusted keeps
+	// whatever name it already has. We have to rename the identity
+	// env.raw itself holds, not just a throwaway copy, so that
+	// removeCertificate later sees the same name we pushed to the
+	// server.
+	env.raw.remote.Cert.Name = addedCertName
+	if err := env.raw.AddCertWithPassword(*env.raw.remote.Cert, trustPassword); err != nil {
+		return errors.Annotatef(err, "adding certificate %q using trust password", addedCertName)
+	}
+	return nil
 }
 
 // BootstrapMessage is part of the Environ interface.
@@ -206,6 +319,35 @@ func (env *environ) Destroy() error {
 	if err := env.base.DestroyEnv(); err != nil {
 		return errors.Trace(err)
 	}
+	if err := env.cleanupProfile(); err != nil {
+		return errors.Trace(err)
+	}
+	if err := env.cleanupStoragePool(); err != nil {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// cleanupProfile removes the model's own "juju-<model-name>" profile.
+// This runs after DestroyEnv has torn down every instance, so the
+// profile is normally unreferenced by the time we get here; but LXD
+// itself is the authority on that, and refuses to delete a profile
+// still attached to an instance rather than us having to track
+// references ourselves, so an in-use error is tolerated rather than
+// treated as a destroy failure. Any profiles named via
+// additional-profiles are left untouched, since the user manages
+// their lifecycle themselves.
+func (env *environ) cleanupProfile() error {
+	hasProfile, err := env.raw.HasProfile(env.profileName())
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !hasProfile {
+		return nil
+	}
+	if err := env.raw.DeleteProfile(env.profileName()); err != nil && !isProfileInUse(err) {
+		return errors.Annotatef(err, "deleting profile %q", env.profileName())
+	}
 	return nil
 }
 
@@ -217,14 +359,13 @@ func (env *environ) DestroyController(controllerUUID string) error {
 	if err := env.destroyHostedModelResources(controllerUUID); err != nil {
 		return errors.Trace(err)
 	}
-	if env.local {
-		// When we're running locally to the LXD host, remove the
-		// certificate from LXD. It will get added back in at
-		// bootstrap time as necessary. For remote LXD, the user
-		// needs to have added the certificate to LXD themselves.
-		if err := env.removeCertificate(); err != nil {
-			return errors.Trace(err)
-		}
+	// Remove the certificate from LXD's trust store; it will get added
+	// back in at bootstrap time as necessary. On a local LXD server it
+	// is removed whatever its name, including one the user pre-trusted
+	// themselves; on a remote server it is removed only if it bears
+	// addedCertName, so one the user pre-trusted there is left alone.
+	if err := env.removeCertificate(); err != nil {
+		return errors.Trace(err)
 	}
 	return nil
 }
@@ -249,10 +390,27 @@ func (env *environ) destroyHostedModelResources(controllerUUID string) error {
 		}
 		names = append(names, string(inst.Id()))
 	}
-	if len(names) > 0 {
-		if err := env.raw.RemoveInstances(prefix, names...); err != nil {
-			return errors.Annotate(err, "removing hosted model instances")
+	if len(names) == 0 {
+		return nil
+	}
+	env.lock.Lock()
+	clustered := env.clusterEnabled
+	members := append([]string(nil), env.clusterMembers...)
+	env.lock.Unlock()
+	if clustered && len(members) > 0 {
+		// Instances may be hosted on any member, so we have to ask
+		// each one in turn; a member that doesn't have a given
+		// instance just reports it not found.
+		for _, member := range members {
+			err := env.raw.RemoveInstancesOnMember(member, prefix, names...)
+			if err != nil && !errors.IsNotFound(err) {
+				return errors.Annotatef(err, "removing hosted model instances on %q", member)
+			}
 		}
+		return nil
+	}
+	if err := env.raw.RemoveInstances(prefix, names...); err != nil {
+		return errors.Annotate(err, "removing hosted model instances")
 	}
 	return nil
 }
@@ -261,6 +419,11 @@ func (env *environ) removeCertificate() error {
 	if env.raw.remote.Cert == nil {
 		return nil
 	}
+	if !env.local && env.raw.remote.Cert.Name != addedCertName {
+		// Not a certificate we added ourselves; leave it for the
+		// user to manage.
+		return nil
+	}
 	fingerprint, err := env.raw.remote.Cert.Fingerprint()
 	if err != nil {
 		return errors.Annotate(err, "generating certificate fingerprint")
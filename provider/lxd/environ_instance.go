@@ -0,0 +1,108 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/instance"
+	"github.com/juju/juju/tools/lxdclient"
+)
+
+// environInstance implements instance.Instance, wrapping a raw LXD
+// container.
+type environInstance struct {
+	raw *lxdclient.Instance
+	env *environ
+}
+
+// Id implements instance.Instance.
+func (inst *environInstance) Id() instance.Id {
+	return instance.Id(inst.raw.Name)
+}
+
+// StartInstance implements environs.InstanceBroker.
+func (env *environ) StartInstance(args environs.StartInstanceParams) (*environs.StartInstanceResult, error) {
+	raw, err := env.newRawInstance(args)
+	if err != nil {
+		return nil, errors.Annotate(err, "starting instance")
+	}
+	inst := &environInstance{raw: raw, env: env}
+	return &environs.StartInstanceResult{
+		Instance: inst,
+	}, nil
+}
+
+// newRawInstance resolves the image for this instance against this
+// model's ordered image sources, creates the underlying LXD container
+// for it, attaching the model's own profile plus any
+// additional-profiles the user configured, and roots its disk on the
+// model's configured storage-pool, if any.
+func (env *environ) newRawInstance(args environs.StartInstanceParams) (*lxdclient.Instance, error) {
+	hostname, err := env.namespace.Hostname(args.InstanceConfig.MachineId)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	sources, err := env.getImageSources()
+	if err != nil {
+		return nil, errors.Annotate(err, "getting image sources")
+	}
+	fingerprint, err := env.raw.EnsureImageExists(args.InstanceConfig.Series, sources)
+	if err != nil {
+		return nil, errors.Annotate(err, "ensuring image exists")
+	}
+	if err := env.publishImageLocally(fingerprint); err != nil {
+		return nil, errors.Annotate(err, "publishing image locally")
+	}
+
+	var tags []string
+	if args.Constraints.Tags != nil {
+		tags = *args.Constraints.Tags
+	}
+	member, err := env.chooseClusterMember(args.Placement, tags)
+	if err != nil {
+		return nil, errors.Annotate(err, "choosing cluster member")
+	}
+
+	spec := lxdclient.InstanceSpec{
+		Name:     hostname,
+		Image:    fingerprint,
+		Profiles: env.instanceProfiles(),
+		Devices:  env.rootDiskDevices(),
+	}
+
+	// member is empty on a standalone LXD server, or when this environ
+	// has not detected a cluster; raw then sends no target and LXD's
+	// own default placement applies. On a detected cluster it always
+	// names a member, the least-loaded one if no placement or tags
+	// were given.
+	raw, err := env.raw.CreateContainer(spec, member)
+	if err != nil {
+		return nil, errors.Annotate(err, "creating container")
+	}
+	return raw, nil
+}
+
+// rootDiskDevices returns the device overrides for a new container's
+// root disk. With no storage-pool configured, it returns nil and the
+// container lands on LXD's own default pool, as before; otherwise the
+// root disk is rooted on the pool named by the storage-pool model
+// config attribute.
+func (env *environ) rootDiskDevices() map[string]map[string]string {
+	pool := env.ecfg.storagePool()
+	if pool == "" {
+		return nil
+	}
+	return map[string]map[string]string{
+		"root": {
+			"type": "disk",
+			"path": "/",
+			"pool": pool,
+		},
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"github.com/juju/errors"
+	gc "gopkg.in/check.v1"
+)
+
+type environSuite struct{}
+
+var _ = gc.Suite(&environSuite{})
+
+func (s *environSuite) TestProfileConfigOverridesDefaults(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{
+		lxdProfileConfigKey: map[string]interface{}{
+			"security.nesting": "false",
+			"custom.key":       "value",
+		},
+	}}}
+	merged := env.profileConfig()
+	c.Assert(merged["boot.autostart"], gc.Equals, "true")
+	c.Assert(merged["security.nesting"], gc.Equals, "false")
+	c.Assert(merged["custom.key"], gc.Equals, "value")
+}
+
+func (s *environSuite) TestIsProfileInUseRecognisesLXDsError(c *gc.C) {
+	c.Assert(isProfileInUse(errors.New("Profile is currently in use")), gc.Equals, true)
+	c.Assert(isProfileInUse(errors.New("not found")), gc.Equals, false)
+	c.Assert(isProfileInUse(nil), gc.Equals, false)
+}
@@ -0,0 +1,82 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/tools/lxdclient"
+)
+
+// getImageSources returns the ordered list of LXD remotes that
+// EnsureImageExists, and later StartInstance calls, should try when
+// resolving an image, in preference order:
+//
+//  1. this model's own image-metadata-url, if the user configured one
+//     for an offline/air-gapped site;
+//  2. the controller's own image server, if image-stream-cache is
+//     enabled and this isn't the controller itself (so a multi-node
+//     deployment copies images from the controller instead of each
+//     host independently pulling them from the upstream remote);
+//  3. the usual public simplestreams/image-download remote, as a
+//     fallback - unless image-metadata-url is set, in which case the
+//     whole point was to avoid any calls to cloud-images.ubuntu.com,
+//     so the upstream remotes are left out rather than silently
+//     defeating that.
+func (env *environ) getImageSources() ([]lxdclient.Remote, error) {
+	offline := env.ecfg.imageMetadataURL() != ""
+
+	var remotes []lxdclient.Remote
+	if offline {
+		remotes = append(remotes, lxdclient.Remote{
+			Name:     "image-metadata-url",
+			Host:     env.ecfg.imageMetadataURL(),
+			Protocol: lxdclient.SimplestreamsProtocol,
+			Public:   true,
+		})
+	}
+	if env.ecfg.imageStreamCache() && !env.local {
+		remote, ok := env.raw.ControllerImageRemote()
+		if ok {
+			remotes = append(remotes, remote)
+		}
+	}
+	if !offline {
+		remotes = append(remotes, lxdclient.CloudImagesRemote, lxdclient.CloudImagesDailyRemote)
+	}
+	return remotes, nil
+}
+
+// publishImageLocally publishes the image with the given fingerprint
+// as a public image on this controller's own LXD daemon, so it can
+// act as an image server for the rest of the model. It's a no-op
+// unless image-stream-cache is enabled. newRawInstance calls this
+// after every EnsureImageExists, but it only actually publishes once
+// per fingerprint: the first call, right after the bootstrap
+// controller's own EnsureImageExists, does the real work, and later
+// calls (including ones made while starting the controller's own
+// follow-up units) are cheap no-ops.
+func (env *environ) publishImageLocally(fingerprint string) error {
+	if !env.ecfg.imageStreamCache() {
+		return nil
+	}
+
+	env.lock.Lock()
+	if env.publishedImages == nil {
+		env.publishedImages = make(map[string]bool)
+	}
+	if env.publishedImages[fingerprint] {
+		env.lock.Unlock()
+		return nil
+	}
+	env.publishedImages[fingerprint] = true
+	env.lock.Unlock()
+
+	if err := env.raw.PublishImage(fingerprint); err != nil {
+		return errors.Annotatef(err, "publishing image %q locally", fingerprint)
+	}
+	return nil
+}
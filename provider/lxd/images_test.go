@@ -0,0 +1,48 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/tools/lxdclient"
+)
+
+type imagesSuite struct{}
+
+var _ = gc.Suite(&imagesSuite{})
+
+func (s *imagesSuite) TestGetImageSourcesDefaultsToCloudImages(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{}}}
+	sources, err := env.getImageSources()
+	c.Assert(err, gc.IsNil)
+	c.Assert(sources, gc.DeepEquals, []lxdclient.Remote{
+		lxdclient.CloudImagesRemote,
+		lxdclient.CloudImagesDailyRemote,
+	})
+}
+
+func (s *imagesSuite) TestGetImageSourcesUsesOnlyImageMetadataURLWhenSet(c *gc.C) {
+	// An air-gapped site sets image-metadata-url precisely to avoid any
+	// calls to cloud-images.ubuntu.com; falling back to it anyway would
+	// defeat the point.
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{
+		imageMetadataURLKey: "https://images.internal/streams",
+	}}}
+	sources, err := env.getImageSources()
+	c.Assert(err, gc.IsNil)
+	c.Assert(sources, gc.HasLen, 1)
+	c.Assert(sources[0].Host, gc.Equals, "https://images.internal/streams")
+}
+
+func (s *imagesSuite) TestPublishImageLocallyNoopWhenCacheDisabled(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{}}}
+	// With image-stream-cache unset this must return before ever
+	// touching env.raw, which is nil here - if it tried to publish,
+	// this would panic.
+	err := env.publishImageLocally("some-fingerprint")
+	c.Assert(err, gc.IsNil)
+}
@@ -0,0 +1,509 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/environs"
+	"github.com/juju/juju/tools/lxdclient"
+)
+
+// lxdSocketPath is the Unix socket a local LXD daemon listens on.
+const lxdSocketPath = "/var/lib/lxd/unix.socket"
+
+// rawProvider is environ's connection to a single LXD server. It
+// talks the LXD REST API directly: over the local Unix socket for a
+// daemon on this host, or over HTTPS using the remote's client
+// certificate otherwise.
+type rawProvider struct {
+	remote lxdclient.Remote
+
+	client *http.Client
+	base   string
+}
+
+// newRawProvider opens a connection to the LXD server described by
+// spec, either over the local Unix socket (local == true) or over
+// HTTPS to spec.Endpoint using whatever client certificate the
+// credential supplies.
+func newRawProvider(spec environs.CloudSpec, local bool) (*rawProvider, error) {
+	clientCert, serverCert, _ := getCerts(spec)
+	remote := lxdclient.Remote{
+		Name:       "juju",
+		Host:       spec.Endpoint,
+		Protocol:   lxdclient.LXDProtocol,
+		Cert:       clientCert,
+		ServerCert: serverCert,
+	}
+
+	if local {
+		return &rawProvider{
+			remote: remote,
+			base:   "http://unix",
+			client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return net.Dial("unix", lxdSocketPath)
+					},
+				},
+			},
+		}, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if clientCert != nil {
+		cert, err := tls.X509KeyPair(clientCert.CertPEM, clientCert.KeyPEM)
+		if err != nil {
+			return nil, errors.Annotate(err, "loading client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &rawProvider{
+		remote: remote,
+		base:   "https://" + spec.Endpoint,
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}, nil
+}
+
+// lxdResponse is the envelope every LXD API response is wrapped in.
+type lxdResponse struct {
+	Error    string          `json:"error"`
+	Metadata json.RawMessage `json:"metadata"`
+}
+
+// do issues method/path against the LXD server, sending body (if
+// non-nil) as the JSON request body and decoding the response
+// metadata into out (if non-nil). A 404 response is reported as
+// errors.NotFound, so callers can use errors.IsNotFound.
+func (p *rawProvider) do(method, path string, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.base+path, reqBody)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Annotatef(err, "calling %s %s", method, path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.NotFoundf("%s", path)
+	}
+	var lxdResp lxdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lxdResp); err != nil {
+		return errors.Annotatef(err, "decoding response from %s %s", method, path)
+	}
+	if lxdResp.Error != "" {
+		return errors.Errorf("%s", lxdResp.Error)
+	}
+	if out != nil && len(lxdResp.Metadata) > 0 {
+		if err := json.Unmarshal(lxdResp.Metadata, out); err != nil {
+			return errors.Annotatef(err, "decoding metadata from %s %s", method, path)
+		}
+	}
+	return nil
+}
+
+// HasProfile reports whether a profile with the given name already
+// exists on the server.
+func (p *rawProvider) HasProfile(name string) (bool, error) {
+	err := p.do("GET", "/1.0/profiles/"+name, nil, nil)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// CreateProfile creates a new profile with the given config and
+// device overrides (e.g. lxd-profile-devices).
+func (p *rawProvider) CreateProfile(name string, config map[string]string, devices map[string]map[string]string) error {
+	return errors.Trace(p.do("POST", "/1.0/profiles", map[string]interface{}{
+		"name":    name,
+		"config":  config,
+		"devices": devices,
+	}, nil))
+}
+
+// UpdateProfile replaces the config and devices of the named profile.
+func (p *rawProvider) UpdateProfile(name string, config map[string]string, devices map[string]map[string]string) error {
+	return errors.Trace(p.do("PUT", "/1.0/profiles/"+name, map[string]interface{}{
+		"config":  config,
+		"devices": devices,
+	}, nil))
+}
+
+// DeleteProfile removes the named profile. LXD refuses the request
+// with an error (not a distinct status code we can key off) if the
+// profile is still attached to an instance; isProfileInUse recognises
+// that case for callers that want to tolerate it.
+func (p *rawProvider) DeleteProfile(name string) error {
+	return errors.Trace(p.do("DELETE", "/1.0/profiles/"+name, nil, nil))
+}
+
+// isProfileInUse reports whether err is the error LXD returns from
+// DeleteProfile when the profile is still attached to an instance.
+func isProfileInUse(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "currently in use")
+}
+
+// CertByFingerprint looks up a trusted certificate by its fingerprint.
+// It returns a not-found error if the server doesn't trust a
+// certificate with that fingerprint.
+func (p *rawProvider) CertByFingerprint(fingerprint string) (lxdclient.Cert, error) {
+	var cert lxdclient.Cert
+	err := p.do("GET", "/1.0/certificates/"+fingerprint, nil, &cert)
+	return cert, errors.Trace(err)
+}
+
+// AddCert adds cert to the server's trust store.
+func (p *rawProvider) AddCert(cert lxdclient.Cert) error {
+	return errors.Trace(p.do("POST", "/1.0/certificates", map[string]interface{}{
+		"type":        "client",
+		"certificate": string(cert.CertPEM),
+		"name":        cert.Name,
+	}, nil))
+}
+
+// RemoveCertByFingerprint removes the trusted certificate with the
+// given fingerprint.
+func (p *rawProvider) RemoveCertByFingerprint(fingerprint string) error {
+	return errors.Trace(p.do("DELETE", "/1.0/certificates/"+fingerprint, nil, nil))
+}
+
+// RemoveInstances removes every instance whose name has the given
+// prefix and matches one of names.
+func (p *rawProvider) RemoveInstances(prefix string, names ...string) error {
+	for _, name := range names {
+		err := p.do("DELETE", "/1.0/instances/"+prefix+name, nil, nil)
+		if err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "removing instance %q", name)
+		}
+	}
+	return nil
+}
+
+// UseClientCert changes the certificate this connection authenticates
+// future requests with, e.g. after generating a fresh client identity
+// because the credential didn't supply one.
+func (p *rawProvider) UseClientCert(cert lxdclient.Cert) error {
+	tlsCert, err := tls.X509KeyPair(cert.CertPEM, cert.KeyPEM)
+	if err != nil {
+		return errors.Annotate(err, "loading client certificate")
+	}
+	transport, ok := p.client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil {
+		// Talking over the local Unix socket; there's no TLS identity
+		// to swap out.
+		return nil
+	}
+	transport.TLSClientConfig.Certificates = []tls.Certificate{tlsCert}
+	p.remote.Cert = &cert
+	return nil
+}
+
+// AddCertWithPassword adds cert to the server's trust store the same
+// way AddCert does, but authenticates the request with a trust
+// password instead of an already-trusted client certificate. This is
+// what lets "juju bootstrap lxd" enrol itself against a remote LXD
+// server that only has a trust-password configured, mirroring what
+// "lxc remote add" does.
+func (p *rawProvider) AddCertWithPassword(cert lxdclient.Cert, trustPassword string) error {
+	return errors.Trace(p.do("POST", "/1.0/certificates", map[string]interface{}{
+		"type":        "client",
+		"certificate": string(cert.CertPEM),
+		"name":        cert.Name,
+		"password":    trustPassword,
+	}, nil))
+}
+
+// storageVolume is the subset of an LXD custom storage volume's
+// fields that lxdVolumeSource/lxdFilesystemSource need.
+type storageVolume struct {
+	Name   string
+	Config map[string]string
+}
+
+// HasStoragePool reports whether a storage pool with the given name
+// already exists on the server.
+func (p *rawProvider) HasStoragePool(pool string) (bool, error) {
+	err := p.do("GET", "/1.0/storage-pools/"+pool, nil, nil)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	return true, nil
+}
+
+// CreateStoragePool creates a new storage pool with the given driver
+// ("zfs", "dir", "btrfs", ...) and config.
+func (p *rawProvider) CreateStoragePool(pool, driver string, config map[string]string) error {
+	return errors.Trace(p.do("POST", "/1.0/storage-pools", map[string]interface{}{
+		"name":   pool,
+		"driver": driver,
+		"config": config,
+	}, nil))
+}
+
+// StoragePoolConfig returns the config of the named storage pool.
+func (p *rawProvider) StoragePoolConfig(pool string) (map[string]string, error) {
+	var info struct {
+		Config map[string]string `json:"config"`
+	}
+	if err := p.do("GET", "/1.0/storage-pools/"+pool, nil, &info); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return info.Config, nil
+}
+
+// DeleteStoragePool removes the named storage pool.
+func (p *rawProvider) DeleteStoragePool(pool string) error {
+	return errors.Trace(p.do("DELETE", "/1.0/storage-pools/"+pool, nil, nil))
+}
+
+// storageVolumesPath returns the REST path for the custom storage
+// volumes in the given pool.
+func storageVolumesPath(pool string) string {
+	return "/1.0/storage-pools/" + pool + "/volumes/custom"
+}
+
+// StorageVolumes lists the custom storage volumes in the given pool.
+func (p *rawProvider) StorageVolumes(pool string) ([]storageVolume, error) {
+	var volumes []storageVolume
+	err := p.do("GET", storageVolumesPath(pool)+"?recursion=1", nil, &volumes)
+	return volumes, errors.Trace(err)
+}
+
+// StorageVolume returns the named custom storage volume in the given
+// pool.
+func (p *rawProvider) StorageVolume(pool, name string) (storageVolume, error) {
+	var volume storageVolume
+	err := p.do("GET", storageVolumesPath(pool)+"/"+name, nil, &volume)
+	return volume, errors.Trace(err)
+}
+
+// StorageVolumeSizeMiB returns the size, in MiB, of the given storage
+// volume, as recorded in its "size" config key (e.g. "2048MB").
+func (p *rawProvider) StorageVolumeSizeMiB(vol storageVolume) uint64 {
+	size := vol.Config["size"]
+	size = strings.TrimSuffix(size, "MB")
+	mib, err := strconv.ParseUint(size, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return mib
+}
+
+// CreateStorageVolume creates a new custom storage volume with the
+// given name and config in the given pool.
+func (p *rawProvider) CreateStorageVolume(pool, name string, config map[string]string) error {
+	return errors.Trace(p.do("POST", storageVolumesPath(pool), map[string]interface{}{
+		"name":   name,
+		"type":   "custom",
+		"config": config,
+	}, nil))
+}
+
+// DeleteStorageVolume removes the named custom storage volume from
+// the given pool.
+func (p *rawProvider) DeleteStorageVolume(pool, name string) error {
+	return errors.Trace(p.do("DELETE", storageVolumesPath(pool)+"/"+name, nil, nil))
+}
+
+// AttachDisk attaches device (a "disk" device definition) to the
+// named instance under the given device name.
+func (p *rawProvider) AttachDisk(instanceName, deviceName string, device map[string]string) error {
+	return errors.Trace(p.do("PATCH", "/1.0/instances/"+instanceName, map[string]interface{}{
+		"devices": map[string]interface{}{deviceName: device},
+	}, nil))
+}
+
+// DetachDisk removes the named device from the named instance.
+func (p *rawProvider) DetachDisk(instanceName, deviceName string) error {
+	return errors.Trace(p.do("PATCH", "/1.0/instances/"+instanceName, map[string]interface{}{
+		"devices": map[string]interface{}{deviceName: nil},
+	}, nil))
+}
+
+// ControllerImageRemote returns the lxdclient.Remote that points at
+// this server's own image store, for use by other hosts in the model
+// that want to copy images from the controller instead of an upstream
+// simplestreams source. ok is false if this server isn't reachable
+// the way the rest of the model would need to reach it (e.g. it has
+// no configured public address).
+func (p *rawProvider) ControllerImageRemote() (remote lxdclient.Remote, ok bool) {
+	if p.remote.Host == "" {
+		return lxdclient.Remote{}, false
+	}
+	return lxdclient.Remote{
+		Name:     "juju-controller",
+		Host:     p.remote.Host,
+		Protocol: lxdclient.LXDProtocol,
+		Public:   false,
+	}, true
+}
+
+// PublishImage marks the image with the given fingerprint as public
+// on this server, so other hosts copying from ControllerImageRemote
+// can fetch it without authenticating.
+func (p *rawProvider) PublishImage(fingerprint string) error {
+	return errors.Trace(p.do("PATCH", "/1.0/images/"+fingerprint, map[string]interface{}{
+		"public": true,
+	}, nil))
+}
+
+// EnsureImageExists makes sure an image for series exists on this
+// server, trying each of sources in order until one has it. If the
+// image isn't already local (i.e. it came from anywhere but our own
+// image cache), it's copied onto this server first, so the container
+// created from it doesn't depend on the source remaining reachable.
+// It returns the fingerprint of the resulting local image.
+func (p *rawProvider) EnsureImageExists(series string, sources []lxdclient.Remote) (string, error) {
+	var alias struct {
+		Target string `json:"target"`
+	}
+	for _, source := range sources {
+		if err := p.do("GET", "/1.0/images/aliases/"+series, nil, &alias); err == nil {
+			return alias.Target, nil
+		} else if !errors.IsNotFound(err) {
+			return "", errors.Annotatef(err, "resolving %q from %q", series, source.Name)
+		}
+		fingerprint, err := p.copyImageFromRemote(source, series)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return "", errors.Annotatef(err, "copying %q from %q", series, source.Name)
+		}
+		return fingerprint, nil
+	}
+	return "", errors.NotFoundf("image for series %q in any configured source", series)
+}
+
+// ClusterStatus queries the server's /1.0/cluster endpoint, returning
+// whether clustering is enabled and, if so, the names of every member.
+// A standalone LXD server, or an LXD version that predates clustering,
+// reports enabled == false rather than erroring.
+func (p *rawProvider) ClusterStatus() (enabled bool, members []string, err error) {
+	var cluster struct {
+		Enabled bool `json:"enabled"`
+	}
+	if doErr := p.do("GET", "/1.0/cluster", nil, &cluster); doErr != nil {
+		if errors.IsNotFound(doErr) {
+			return false, nil, nil
+		}
+		return false, nil, errors.Trace(doErr)
+	}
+	if !cluster.Enabled {
+		return false, nil, nil
+	}
+	var memberURLs []string
+	if err := p.do("GET", "/1.0/cluster/members", nil, &memberURLs); err != nil {
+		return false, nil, errors.Trace(err)
+	}
+	for _, url := range memberURLs {
+		members = append(members, path.Base(url))
+	}
+	return true, members, nil
+}
+
+// InstanceCountByMember returns, for each of the given cluster
+// members, the number of instances currently hosted on it, so the
+// scheduler can bin-pack new containers onto the least-loaded member.
+func (p *rawProvider) InstanceCountByMember(members []string) (map[string]int, error) {
+	counts := make(map[string]int, len(members))
+	for _, member := range members {
+		var names []string
+		err := p.do("GET", "/1.0/instances?recursion=0&target="+member, nil, &names)
+		if err != nil {
+			return nil, errors.Annotatef(err, "counting instances on %q", member)
+		}
+		counts[member] = len(names)
+	}
+	return counts, nil
+}
+
+// RemoveInstancesOnMember removes every instance whose name has the
+// given prefix and matches one of names, on the named cluster member
+// specifically.
+func (p *rawProvider) RemoveInstancesOnMember(member, prefix string, names ...string) error {
+	for _, name := range names {
+		err := p.do("DELETE", "/1.0/instances/"+prefix+name+"?target="+member, nil, nil)
+		if err != nil && !errors.IsNotFound(err) {
+			return errors.Annotatef(err, "removing instance %q on %q", name, member)
+		}
+	}
+	return nil
+}
+
+// CreateContainer creates a new container from spec. If member is
+// non-empty, the container is targeted at that cluster member via
+// LXD's target= query parameter; on a standalone server member is
+// empty and LXD's own default placement applies.
+func (p *rawProvider) CreateContainer(spec lxdclient.InstanceSpec, member string) (*lxdclient.Instance, error) {
+	reqPath := "/1.0/instances"
+	if member != "" {
+		reqPath += "?target=" + member
+	}
+	var instance lxdclient.Instance
+	err := p.do("POST", reqPath, map[string]interface{}{
+		"name":     spec.Name,
+		"profiles": spec.Profiles,
+		"devices":  spec.Devices,
+		"source":   map[string]interface{}{"type": "image", "fingerprint": spec.Image},
+	}, &instance)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &instance, nil
+}
+
+// copyImageFromRemote asks this server to fetch the image aliased to
+// series from source and store it locally, returning the fingerprint
+// of the copy.
+func (p *rawProvider) copyImageFromRemote(source lxdclient.Remote, series string) (string, error) {
+	var created struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	err := p.do("POST", "/1.0/images", map[string]interface{}{
+		"source": map[string]interface{}{
+			"type":        "image",
+			"mode":        "pull",
+			"server":      source.Host,
+			"protocol":    source.Protocol,
+			"alias":       series,
+			"certificate": source.ServerCert,
+		},
+	}, &created)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	return created.Fingerprint, nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	"fmt"
+
+	"github.com/juju/errors"
+
+	"github.com/juju/juju/storage"
+)
+
+// storageProviderType is the only storage provider type this provider
+// registers: custom LXD storage volumes on a named storage pool.
+const storageProviderType = storage.ProviderType("lxd")
+
+// juju-owned custom volumes carry this key in their LXD config so we
+// can tell our volumes apart from ones the user created by hand when
+// it comes time to clean up.
+const volumeAttrJujuModelUUID = "user.juju-model-uuid"
+
+// poolAttrJujuCreated marks a storage pool that Juju created on the
+// user's behalf (as opposed to one that already existed on the LXD
+// server), so Destroy knows it's ours to remove once it's empty.
+const poolAttrJujuCreated = "user.juju-created"
+
+// StorageProviderTypes implements storage.ProviderRegistry.
+func (env *environ) StorageProviderTypes() ([]storage.ProviderType, error) {
+	return []storage.ProviderType{storageProviderType}, nil
+}
+
+// StorageProvider implements storage.ProviderRegistry.
+func (env *environ) StorageProvider(t storage.ProviderType) (storage.Provider, error) {
+	if t != storageProviderType {
+		return nil, errors.NotFoundf("storage provider %q", t)
+	}
+	return &lxdStorageProvider{env: env}, nil
+}
+
+// ensureStoragePool creates the model's configured storage pool if it
+// doesn't already exist, marking it as Juju-created so Destroy knows
+// it's safe to remove again once it's empty. If the user didn't set
+// storage-pool, containers land on LXD's own default pool and there's
+// nothing for us to manage.
+func (env *environ) ensureStoragePool() error {
+	pool := env.ecfg.storagePool()
+	if pool == "" {
+		return nil
+	}
+	exists, err := env.raw.HasStoragePool(pool)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if exists {
+		return nil
+	}
+	config := map[string]string{poolAttrJujuCreated: "true"}
+	if source := env.ecfg.storagePoolSource(); source != "" {
+		config["source"] = source
+	}
+	if err := env.raw.CreateStoragePool(pool, env.ecfg.storagePoolDriver(), config); err != nil {
+		return errors.Annotatef(err, "creating storage pool %q", pool)
+	}
+	return nil
+}
+
+// cleanupStoragePool removes the model's storage volumes, and the
+// pool itself if Juju created it and nothing else is using it
+// anymore. A pool that pre-dates Juju, or that other models still
+// have volumes on, is left alone.
+func (env *environ) cleanupStoragePool() error {
+	pool := env.ecfg.storagePool()
+	if pool == "" {
+		return nil
+	}
+	volumes, err := env.raw.StorageVolumes(pool)
+	if err != nil {
+		return errors.Annotatef(err, "listing volumes in pool %q", pool)
+	}
+	var ours, remaining int
+	for _, v := range volumes {
+		if v.Config[volumeAttrJujuModelUUID] != env.uuid {
+			remaining++
+			continue
+		}
+		if err := env.raw.DeleteStorageVolume(pool, v.Name); err != nil {
+			return errors.Annotatef(err, "deleting volume %q", v.Name)
+		}
+		ours++
+	}
+	if remaining > 0 {
+		// Other models still have volumes on this pool.
+		return nil
+	}
+	jujuCreated, err := env.raw.StoragePoolConfig(pool)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if jujuCreated[poolAttrJujuCreated] != "true" {
+		return nil
+	}
+	if err := env.raw.DeleteStoragePool(pool); err != nil {
+		return errors.Annotatef(err, "deleting storage pool %q", pool)
+	}
+	return nil
+}
+
+// lxdStorageProvider is a storage.Provider that creates volumes as
+// LXD custom storage volumes on the model's storage pool, attaching
+// them to containers as "disk" devices of type "custom".
+type lxdStorageProvider struct {
+	env *environ
+}
+
+var _ storage.Provider = (*lxdStorageProvider)(nil)
+
+// ValidateConfig implements storage.Provider.
+func (p *lxdStorageProvider) ValidateConfig(*storage.Config) error {
+	return nil
+}
+
+// Supports implements storage.Provider.
+func (p *lxdStorageProvider) Supports(kind storage.StorageKind) bool {
+	switch kind {
+	case storage.StorageKindBlock, storage.StorageKindFilesystem:
+		return true
+	default:
+		return false
+	}
+}
+
+// Scope implements storage.Provider.
+func (p *lxdStorageProvider) Scope() storage.Scope {
+	return storage.ScopeEnviron
+}
+
+// Dynamic implements storage.Provider.
+func (p *lxdStorageProvider) Dynamic() bool {
+	return true
+}
+
+// VolumeSource implements storage.Provider.
+func (p *lxdStorageProvider) VolumeSource(*storage.Config) (storage.VolumeSource, error) {
+	return &lxdVolumeSource{env: p.env}, nil
+}
+
+// FilesystemSource implements storage.Provider.
+func (p *lxdStorageProvider) FilesystemSource(*storage.Config) (storage.FilesystemSource, error) {
+	return &lxdFilesystemSource{env: p.env}, nil
+}
+
+// lxdVolumeSource creates, attaches and destroys LXD custom storage
+// volumes on the model's configured storage pool.
+type lxdVolumeSource struct {
+	env *environ
+}
+
+var _ storage.VolumeSource = (*lxdVolumeSource)(nil)
+
+func (v *lxdVolumeSource) pool() string {
+	return v.env.ecfg.storagePool()
+}
+
+// CreateVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) CreateVolumes(params []storage.VolumeParams) (_ []storage.CreateVolumesResult, err error) {
+	results := make([]storage.CreateVolumesResult, len(params))
+	for i, p := range params {
+		config := map[string]string{
+			volumeAttrJujuModelUUID: v.env.uuid,
+			"size":                  fmt.Sprintf("%dMB", p.Size),
+		}
+		if err := v.env.raw.CreateStorageVolume(v.pool(), p.Tag.String(), config); err != nil {
+			results[i].Error = errors.Annotatef(err, "creating volume %q", p.Tag.Id())
+			continue
+		}
+		results[i].Volume = &storage.Volume{
+			Tag: p.Tag,
+			VolumeInfo: storage.VolumeInfo{
+				VolumeId: p.Tag.String(),
+				Size:     p.Size,
+			},
+		}
+	}
+	return results, nil
+}
+
+// ListVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) ListVolumes() ([]string, error) {
+	volumes, err := v.env.raw.StorageVolumes(v.pool())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var ids []string
+	for _, vol := range volumes {
+		if vol.Config[volumeAttrJujuModelUUID] == v.env.uuid {
+			ids = append(ids, vol.Name)
+		}
+	}
+	return ids, nil
+}
+
+// DescribeVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) DescribeVolumes(volIds []string) ([]storage.DescribeVolumesResult, error) {
+	results := make([]storage.DescribeVolumesResult, len(volIds))
+	for i, id := range volIds {
+		vol, err := v.env.raw.StorageVolume(v.pool(), id)
+		if err != nil {
+			results[i].Error = errors.Trace(err)
+			continue
+		}
+		results[i].VolumeInfo = &storage.VolumeInfo{
+			VolumeId: id,
+			Size:     v.env.raw.StorageVolumeSizeMiB(vol),
+		}
+	}
+	return results, nil
+}
+
+// DestroyVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) DestroyVolumes(volIds []string) ([]error, error) {
+	results := make([]error, len(volIds))
+	for i, id := range volIds {
+		results[i] = errors.Trace(v.env.raw.DeleteStorageVolume(v.pool(), id))
+	}
+	return results, nil
+}
+
+// ReleaseVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) ReleaseVolumes(volIds []string) ([]error, error) {
+	// LXD custom volumes aren't cloud resources we can hand off to
+	// another controller; releasing one is the same as destroying it.
+	return v.DestroyVolumes(volIds)
+}
+
+// ValidateVolumeParams implements storage.VolumeSource.
+func (v *lxdVolumeSource) ValidateVolumeParams(storage.VolumeParams) error {
+	if v.pool() == "" {
+		return errors.NotValidf("dynamic LXD storage without a storage-pool model config attribute")
+	}
+	return nil
+}
+
+// AttachVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) AttachVolumes(params []storage.VolumeAttachmentParams) ([]storage.AttachVolumesResult, error) {
+	results := make([]storage.AttachVolumesResult, len(params))
+	for i, p := range params {
+		device := map[string]string{
+			"type":   "disk",
+			"source": p.VolumeId,
+			"pool":   v.pool(),
+			"path":   p.Path,
+		}
+		if err := v.env.raw.AttachDisk(string(p.InstanceId), p.VolumeId, device); err != nil {
+			results[i].Error = errors.Annotatef(err, "attaching volume %q to %q", p.VolumeId, p.InstanceId)
+			continue
+		}
+		results[i].VolumeAttachment = &storage.VolumeAttachment{
+			Volume:  p.Volume,
+			Machine: p.Machine,
+			VolumeAttachmentInfo: storage.VolumeAttachmentInfo{
+				DeviceName: p.VolumeId,
+			},
+		}
+	}
+	return results, nil
+}
+
+// DetachVolumes implements storage.VolumeSource.
+func (v *lxdVolumeSource) DetachVolumes(params []storage.VolumeAttachmentParams) ([]error, error) {
+	results := make([]error, len(params))
+	for i, p := range params {
+		results[i] = errors.Trace(v.env.raw.DetachDisk(string(p.InstanceId), p.VolumeId))
+	}
+	return results, nil
+}
+
+// lxdFilesystemSource creates, attaches and destroys LXD custom
+// storage volumes on the model's configured storage pool, the same
+// way lxdVolumeSource does for block storage; LXD custom volumes
+// serve either role depending on how they're attached.
+type lxdFilesystemSource struct {
+	env *environ
+}
+
+var _ storage.FilesystemSource = (*lxdFilesystemSource)(nil)
+
+func (f *lxdFilesystemSource) pool() string {
+	return f.env.ecfg.storagePool()
+}
+
+// ValidateFilesystemParams implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) ValidateFilesystemParams(storage.FilesystemParams) error {
+	if f.pool() == "" {
+		return errors.NotValidf("dynamic LXD storage without a storage-pool model config attribute")
+	}
+	return nil
+}
+
+// CreateFilesystems implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) CreateFilesystems(params []storage.FilesystemParams) ([]storage.CreateFilesystemsResult, error) {
+	results := make([]storage.CreateFilesystemsResult, len(params))
+	for i, p := range params {
+		config := map[string]string{
+			volumeAttrJujuModelUUID: f.env.uuid,
+			"size":                  fmt.Sprintf("%dMB", p.Size),
+		}
+		if err := f.env.raw.CreateStorageVolume(f.pool(), p.Tag.String(), config); err != nil {
+			results[i].Error = errors.Annotatef(err, "creating filesystem %q", p.Tag.Id())
+			continue
+		}
+		results[i].Filesystem = &storage.Filesystem{
+			Tag: p.Tag,
+			FilesystemInfo: storage.FilesystemInfo{
+				FilesystemId: p.Tag.String(),
+				Size:         p.Size,
+			},
+		}
+	}
+	return results, nil
+}
+
+// DestroyFilesystems implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) DestroyFilesystems(filesystemIds []string) ([]error, error) {
+	results := make([]error, len(filesystemIds))
+	for i, id := range filesystemIds {
+		results[i] = errors.Trace(f.env.raw.DeleteStorageVolume(f.pool(), id))
+	}
+	return results, nil
+}
+
+// ReleaseFilesystems implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) ReleaseFilesystems(filesystemIds []string) ([]error, error) {
+	return f.DestroyFilesystems(filesystemIds)
+}
+
+// AttachFilesystems implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) AttachFilesystems(params []storage.FilesystemAttachmentParams) ([]storage.AttachFilesystemsResult, error) {
+	results := make([]storage.AttachFilesystemsResult, len(params))
+	for i, p := range params {
+		device := map[string]string{
+			"type":   "disk",
+			"source": p.FilesystemId,
+			"pool":   f.pool(),
+			"path":   p.Path,
+		}
+		if err := f.env.raw.AttachDisk(string(p.InstanceId), p.FilesystemId, device); err != nil {
+			results[i].Error = errors.Annotatef(err, "attaching filesystem %q to %q", p.FilesystemId, p.InstanceId)
+			continue
+		}
+		results[i].FilesystemAttachment = &storage.FilesystemAttachment{
+			Filesystem: p.Filesystem,
+			Machine:    p.Machine,
+			FilesystemAttachmentInfo: storage.FilesystemAttachmentInfo{
+				Path: p.Path,
+			},
+		}
+	}
+	return results, nil
+}
+
+// DetachFilesystems implements storage.FilesystemSource.
+func (f *lxdFilesystemSource) DetachFilesystems(params []storage.FilesystemAttachmentParams) ([]error, error) {
+	results := make([]error, len(params))
+	for i, p := range params {
+		results[i] = errors.Trace(f.env.raw.DetachDisk(string(p.InstanceId), p.FilesystemId))
+	}
+	return results, nil
+}
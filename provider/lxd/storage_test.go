@@ -0,0 +1,52 @@
+// Copyright 2016 Canonical Ltd.
+// Licensed under the AGPLv3, see LICENCE file for details.
+
+// +build go1.3
+
+package lxd
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"github.com/juju/juju/storage"
+)
+
+type storageSuite struct{}
+
+var _ = gc.Suite(&storageSuite{})
+
+func (s *storageSuite) TestProviderTraits(c *gc.C) {
+	p := &lxdStorageProvider{}
+	c.Assert(p.Supports(storage.StorageKindBlock), gc.Equals, true)
+	c.Assert(p.Supports(storage.StorageKindFilesystem), gc.Equals, true)
+	c.Assert(p.Scope(), gc.Equals, storage.ScopeEnviron)
+	c.Assert(p.Dynamic(), gc.Equals, true)
+}
+
+func (s *storageSuite) TestVolumeAndFilesystemSourcesUseConfiguredPool(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{
+		storagePoolKey: "juju-pool",
+	}}}
+
+	vs, err := (&lxdStorageProvider{env: env}).VolumeSource(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(vs.(*lxdVolumeSource).pool(), gc.Equals, "juju-pool")
+
+	fs, err := (&lxdStorageProvider{env: env}).FilesystemSource(nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(fs.(*lxdFilesystemSource).pool(), gc.Equals, "juju-pool")
+}
+
+func (s *storageSuite) TestValidateVolumeParamsRequiresPool(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{}}}
+	v := &lxdVolumeSource{env: env}
+	err := v.ValidateVolumeParams(storage.VolumeParams{})
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *storageSuite) TestValidateFilesystemParamsRequiresPool(c *gc.C) {
+	env := &environ{ecfg: &environConfig{attrs: map[string]interface{}{}}}
+	f := &lxdFilesystemSource{env: env}
+	err := f.ValidateFilesystemParams(storage.FilesystemParams{})
+	c.Assert(err, gc.NotNil)
+}